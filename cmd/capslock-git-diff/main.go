@@ -25,6 +25,16 @@
 // If the environment variable CAPSLOCKTOOLSTMPDIR is set and non-empty, it
 // specifies the directory where temporary files are created.  Otherwise the
 // system temporary directory is used.
+//
+// Pass -policy=FILE to gate the exit code on a YAML or JSON policy file
+// describing which capability additions are acceptable per package, rather
+// than failing on any difference.  See Policy for the file format.
+//
+// When a revision other than "." is analyzed, a go.work file at the root of
+// the repository is synced with `go work sync`, and relative replace
+// directives that point outside the repository are followed into clones of
+// their own repositories at the same revision; neither step can be turned
+// off.  Pass -vendor if the repository vendors its dependencies.
 package main
 
 import (
@@ -38,7 +48,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"text/tabwriter"
+	"sync"
 
 	cpb "github.com/google/capslock/proto"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -48,6 +58,10 @@ var (
 	verbose          = flag.Bool("v", false, "enable verbose logging")
 	granularity      = flag.String("granularity", "", "the granularity to use for comparisons")
 	flagCapabilities = flag.String("capabilities", "", "if non-empty, a comma-separated list of capabilities to pass to capslock")
+	jobs             = flag.Int("jobs", 2, "number of revisions to analyze concurrently")
+	format           = flag.String("format", "text", "output format for the diff: text, json, or sarif")
+	policyFile       = flag.String("policy", "", "path to a YAML or JSON policy file; if set, gates the exit code on which added capabilities it allows")
+	vendor           = flag.Bool("vendor", false, "analyze using vendored dependencies (passes GOFLAGS=-mod=vendor through to capslock)")
 )
 
 func vlog(format string, a ...any) {
@@ -57,11 +71,17 @@ func vlog(format string, a ...any) {
 	log.Printf(format, a...)
 }
 
-// run executes the specified command and writes its stdout to w.
-func run(w io.Writer, command string, args ...string) error {
-	vlog("running %q with args %q", command, args)
+// run executes the specified command in dir (the current directory, if dir
+// is empty) with env appended to the current environment, and writes its
+// stdout to w.
+func run(w io.Writer, dir string, env []string, command string, args ...string) error {
+	vlog("running %q with args %q in directory %q with env %q", command, args, dir, env)
 	cmd := exec.Command(command, args...)
+	cmd.Dir = dir
 	cmd.Stdout = w
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	if *verbose {
 		cmd.Stderr = os.Stderr
 	}
@@ -71,10 +91,24 @@ func run(w io.Writer, command string, args ...string) error {
 	return nil
 }
 
-func AnalyzeAtRevision(rev, pkgname string) (cil *cpb.CapabilityInfoList, err error) {
+// AnalyzeOptions controls how AnalyzeAtRevision runs capslock.  The go.work
+// and replace directive handling done by prepareWorkspace is not set here;
+// it always runs for revisions other than ".".
+type AnalyzeOptions struct {
+	// Vendor, if true, passes GOFLAGS=-mod=vendor through to capslock so
+	// that vendored sources are analyzed instead of the module cache.
+	Vendor bool
+}
+
+// AnalyzeAtRevision runs capslock against pkgname as it exists at rev.  If
+// rev is not ".", a temporary clone of the current repository is made and
+// reset to rev; the clone's directory is passed to the analysis via
+// cmd.Dir, so this function is safe to call concurrently for different
+// revisions.
+func AnalyzeAtRevision(rev, pkgname string, opts AnalyzeOptions) (cil *cpb.CapabilityInfoList, err error) {
 	vlog("analyzing at revision %q", rev)
 	if rev == "." {
-		return callCapslock(rev, pkgname)
+		return callCapslock("", rev, pkgname, opts)
 	}
 	// Make a temporary directory.
 	tmpdir, err := os.MkdirTemp(os.Getenv("CAPSLOCKTOOLSTMPDIR"), "")
@@ -83,54 +117,45 @@ func AnalyzeAtRevision(rev, pkgname string) (cil *cpb.CapabilityInfoList, err er
 	}
 	// Get the location of the .git directory, so we can make a temporary clone.
 	var b bytes.Buffer
-	if err = run(&b, "git", "rev-parse", "--git-dir"); err != nil {
+	if err = run(&b, "", nil, "git", "rev-parse", "--git-dir"); err != nil {
 		return nil, err
 	}
 	gitdir := strings.TrimSuffix(b.String(), "\n")
 	vlog("git directory: %q", gitdir)
 	b.Reset()
 	// Get the relative directory within the git repository.
-	if err = run(&b, "git", "rev-parse", "--show-prefix"); err != nil {
+	if err = run(&b, "", nil, "git", "rev-parse", "--show-prefix"); err != nil {
 		return nil, err
 	}
 	prefix := strings.TrimSuffix(b.String(), "\n")
 	vlog("current path in repository: %q", prefix)
 	b.Reset()
-	// Clone the repo.
-	if err = run(nil, "git", "clone", "--shared", "--no-checkout", "--", gitdir, tmpdir); err != nil {
+	// Get the absolute path of the repository, in case we need to follow
+	// replace directives that point outside of it.
+	if err = run(&b, "", nil, "git", "rev-parse", "--show-toplevel"); err != nil {
 		return nil, err
 	}
-	// Temporarily switch directory.
-	wd, err := os.Getwd()
-	if err != nil {
+	origRoot := strings.TrimSuffix(b.String(), "\n")
+	b.Reset()
+	// Clone the repo.
+	if err = run(nil, "", nil, "git", "clone", "--shared", "--no-checkout", "--", gitdir, tmpdir); err != nil {
 		return nil, err
 	}
-	defer func() {
-		// Switch back to the original directory.
-		err1 := os.Chdir(wd)
-		if err == nil && err1 != nil {
-			err = fmt.Errorf("returning to working directory: %w", err1)
-		}
-		vlog("returned to working directory %q", wd)
-	}()
-	if err = os.Chdir(tmpdir); err != nil {
-		return nil, fmt.Errorf("switching to temporary directory: %w", err)
-	}
-	vlog("switched to directory %q", tmpdir)
 	// Reset to the requested revision.
-	if err = run(nil, "git", "reset", "--hard", rev); err != nil {
+	if err = run(nil, tmpdir, nil, "git", "reset", "--hard", rev); err != nil {
 		return nil, err
 	}
-	// Go to the same directory in the clone.
-	path := filepath.Join(tmpdir, prefix)
-	if err = os.Chdir(path); err != nil {
-		return nil, fmt.Errorf("switching to temporary directory: %w", err)
+	if err = prepareWorkspace(tmpdir, prefix, origRoot, rev); err != nil {
+		return nil, err
 	}
-	vlog("switched to directory %q", path)
-	return callCapslock(rev, pkgname)
+	// Analyze the same directory in the clone, without touching the
+	// process-wide working directory.
+	dir := filepath.Join(tmpdir, prefix)
+	vlog("analyzing directory %q", dir)
+	return callCapslock(dir, rev, pkgname, opts)
 }
 
-func callCapslock(rev, pkgname string) (cil *cpb.CapabilityInfoList, err error) {
+func callCapslock(dir, rev, pkgname string, opts AnalyzeOptions) (cil *cpb.CapabilityInfoList, err error) {
 	// Call capslock.
 	var b bytes.Buffer
 	args := []string{
@@ -141,7 +166,11 @@ func callCapslock(rev, pkgname string) (cil *cpb.CapabilityInfoList, err error)
 	if *flagCapabilities != "" {
 		args = append(args, "-capabilities="+*flagCapabilities)
 	}
-	if err = run(&b, "capslock", args...); err != nil {
+	var env []string
+	if opts.Vendor {
+		env = append(env, "GOFLAGS=-mod=vendor")
+	}
+	if err = run(&b, dir, env, "capslock", args...); err != nil {
 		return nil, err
 	}
 	if *verbose {
@@ -160,6 +189,31 @@ func callCapslock(rev, pkgname string) (cil *cpb.CapabilityInfoList, err error)
 	return cil, nil
 }
 
+// analyzeRevisions runs AnalyzeAtRevision for each of revisions, using up to
+// *jobs goroutines concurrently, and returns the results in the same order.
+func analyzeRevisions(revisions []string, pkgname string, opts AnalyzeOptions) ([]*cpb.CapabilityInfoList, error) {
+	results := make([]*cpb.CapabilityInfoList, len(revisions))
+	errs := make([]error, len(revisions))
+	sem := make(chan struct{}, max(*jobs, 1))
+	var wg sync.WaitGroup
+	for i, rev := range revisions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rev string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = AnalyzeAtRevision(rev, pkgname, opts)
+		}(i, rev)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
 func main() {
 	flag.Parse()
 	a := flag.Args()
@@ -172,19 +226,29 @@ func main() {
 	} else {
 		panic(fmt.Sprintf("wrong number of arguments: %q", a))
 	}
-	revisions := [2]string{a[0], a[1]}
-	cil1, err := AnalyzeAtRevision(revisions[0], pkgname)
+	revisions := []string{a[0], a[1]}
+	opts := AnalyzeOptions{Vendor: *vendor}
+	results, err := analyzeRevisions(revisions, pkgname, opts)
 	if err != nil {
 		log.Print(err)
 		os.Exit(2)
 	}
-	cil2, err := AnalyzeAtRevision(revisions[1], pkgname)
+	diffs := diffCapabilityInfoLists(results[0], results[1])
+	reporter, err := NewReporter(*format, os.Stdout)
 	if err != nil {
-		log.Print(err)
-		os.Exit(2)
+		log.Fatal("Error: ", err)
 	}
-	different := diffCapabilityInfoLists(cil1, cil2)
-	if different {
+	if err := reporter.Report(diffs); err != nil {
+		log.Fatal("Error: ", err)
+	}
+	if *policyFile != "" {
+		policy, err := loadPolicy(*policyFile)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		os.Exit(policy.Evaluate(diffs))
+	}
+	if len(diffs) > 0 {
 		os.Exit(1)
 	}
 }
@@ -204,7 +268,25 @@ func populateMap(cil *cpb.CapabilityInfoList) capabilitiesMap {
 	return m
 }
 
-func diffCapabilityInfoLists(baseline, current *cpb.CapabilityInfoList) (different bool) {
+// Diff describes a single capability that was added or removed between the
+// two revisions being compared.
+type Diff struct {
+	// Direction is either "added" or "removed".
+	Direction  string
+	Package    string
+	Capability cpb.Capability
+	Path       []*cpb.Function
+}
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+)
+
+// diffCapabilityInfoLists compares baseline and current and returns the
+// capabilities that were added or removed, sorted by capability and then by
+// package.
+func diffCapabilityInfoLists(baseline, current *cpb.CapabilityInfoList) []Diff {
 	baselineMap := populateMap(baseline)
 	currentMap := populateMap(current)
 	var keys []mapKey
@@ -222,43 +304,26 @@ func diffCapabilityInfoLists(baseline, current *cpb.CapabilityInfoList) (differe
 		}
 		return keys[i].key < keys[j].key
 	})
+	var diffs []Diff
 	for _, key := range keys {
 		ciBaseline, inBaseline := baselineMap[key]
 		ciCurrent, inCurrent := currentMap[key]
 		if !inBaseline && inCurrent {
-			if different {
-				fmt.Println()
-			}
-			different = true
-			fmt.Printf("> Package %s has capability %s:\n", key.key, key.capability)
-			printCallPath("> ", ciCurrent.Path)
+			diffs = append(diffs, Diff{
+				Direction:  DiffAdded,
+				Package:    key.key,
+				Capability: key.capability,
+				Path:       ciCurrent.Path,
+			})
 		}
 		if inBaseline && !inCurrent {
-			if different {
-				fmt.Println()
-			}
-			different = true
-			fmt.Printf("< Package %s has capability %s:\n", key.key, key.capability)
-			printCallPath("< ", ciBaseline.Path)
-		}
-	}
-	return different
-}
-
-func printCallPath(prefix string, fns []*cpb.Function) {
-	tw := tabwriter.NewWriter(
-		os.Stdout, // output
-		10,        // minwidth
-		8,         // tabwidth
-		2,         // padding
-		' ',       // padchar
-		0)         // flags
-	for _, f := range fns {
-		tw.Write([]byte(prefix))
-		if f.Site != nil {
-			fmt.Fprint(tw, f.Site.GetFilename(), ":", f.Site.GetLine(), ":", f.Site.GetColumn())
+			diffs = append(diffs, Diff{
+				Direction:  DiffRemoved,
+				Package:    key.key,
+				Capability: key.capability,
+				Path:       ciBaseline.Path,
+			})
 		}
-		fmt.Fprint(tw, "\t", f.GetName(), "\n")
 	}
-	tw.Flush()
+	return diffs
 }
@@ -0,0 +1,154 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes used when -policy is given.
+const (
+	PolicyExitOK       = 0
+	PolicyExitUnlisted = 1
+	PolicyExitDenied   = 2
+)
+
+// Policy declares, per package glob, which capability additions are
+// acceptable and which must never appear.  A policy file is the JSON or YAML
+// encoding of this struct, using the field names in its tags; capabilities
+// are written by name.  For example, in YAML:
+//
+//	rules:
+//	- packages: example.com/mod/...
+//	  allow: [CAPABILITY_FILES]
+//	  deny: [CAPABILITY_EXEC]
+//	baseline:
+//	- package: example.com/mod/net
+//	  capability: CAPABILITY_NETWORK
+type Policy struct {
+	Rules    []PolicyRule    `json:"rules" yaml:"rules"`
+	Baseline []BaselineEntry `json:"baseline" yaml:"baseline"`
+}
+
+// PolicyRule lists the capabilities that are allowed or denied for packages
+// matching Packages, a path.Match-style glob (a trailing "/..." matches the
+// package and everything beneath it, as with `go build`).
+type PolicyRule struct {
+	Packages string   `json:"packages" yaml:"packages"`
+	Allow    []string `json:"allow" yaml:"allow"`
+	Deny     []string `json:"deny" yaml:"deny"`
+}
+
+// BaselineEntry records a capability that a package is already known to
+// have, so that it isn't flagged again as a new, unlisted addition.
+type BaselineEntry struct {
+	Package    string `json:"package" yaml:"package"`
+	Capability string `json:"capability" yaml:"capability"`
+}
+
+// loadPolicy reads a policy from filename.  Files named *.yaml or *.yml are
+// parsed as YAML; everything else is parsed as JSON.
+func loadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+	var p Policy
+	if ext := strings.ToLower(path.Ext(filename)); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(data, &p)
+	} else {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing policy file %q: %w", filename, err)
+	}
+	return &p, nil
+}
+
+// matchPackage reports whether pkg matches the package glob, using
+// path.Match semantics with one extension: a trailing "/..." (or the bare
+// glob "...") matches the package itself and everything beneath it.
+func matchPackage(glob, pkg string) bool {
+	if glob == "..." {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(glob, "/..."); ok {
+		return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	ok, err := path.Match(glob, pkg)
+	return err == nil && ok
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verdict reports whether pkg having capability is explicitly allowed or
+// denied by any matching rule.  Deny takes precedence over allow.
+func (p *Policy) verdict(pkg, capability string) (allowed, denied bool) {
+	for _, rule := range p.Rules {
+		if !matchPackage(rule.Packages, pkg) {
+			continue
+		}
+		if contains(rule.Deny, capability) {
+			denied = true
+		}
+		if contains(rule.Allow, capability) {
+			allowed = true
+		}
+	}
+	return allowed, denied
+}
+
+func (p *Policy) isBaseline(pkg, capability string) bool {
+	for _, b := range p.Baseline {
+		if b.Package == pkg && b.Capability == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate gates diffs against the policy and returns the exit code to use:
+// PolicyExitOK if every added capability is covered by the baseline or an
+// allow rule, PolicyExitDenied if any added capability matches a deny rule,
+// or else PolicyExitUnlisted if any added capability is simply uncovered.
+func (p *Policy) Evaluate(diffs []Diff) int {
+	worst := PolicyExitOK
+	for _, d := range diffs {
+		if d.Direction != DiffAdded {
+			continue
+		}
+		capability := d.Capability.String()
+		if p.isBaseline(d.Package, capability) {
+			continue
+		}
+		allowed, denied := p.verdict(d.Package, capability)
+		switch {
+		case denied:
+			worst = PolicyExitDenied
+		case allowed:
+			// Covered; no change to worst.
+		default:
+			if worst < PolicyExitUnlisted {
+				worst = PolicyExitUnlisted
+			}
+		}
+	}
+	return worst
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"testing"
+
+	cpb "github.com/google/capslock/proto"
+)
+
+func TestMatchPackage(t *testing.T) {
+	for _, tc := range []struct {
+		glob, pkg string
+		want      bool
+	}{
+		{"...", "anything/at/all", true},
+		{"foo/bar/...", "foo/bar", true},
+		{"foo/bar/...", "foo/bar/baz", true},
+		{"foo/bar/...", "foo/barbaz", false},
+		{"foo/bar", "foo/bar", true},
+		{"foo/*", "foo/bar", true},
+		{"foo/*", "foo/bar/baz", false},
+	} {
+		if got := matchPackage(tc.glob, tc.pkg); got != tc.want {
+			t.Errorf("matchPackage(%q, %q) = %v, want %v", tc.glob, tc.pkg, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	capAllowed := cpb.Capability(1)
+	capUnlisted := cpb.Capability(2)
+	capDenied := cpb.Capability(3)
+	capBaseline := cpb.Capability(4)
+
+	policy := &Policy{
+		Rules: []PolicyRule{{
+			Packages: "foo/...",
+			Allow:    []string{capAllowed.String()},
+			Deny:     []string{capDenied.String()},
+		}},
+		Baseline: []BaselineEntry{{
+			Package:    "foo/bar",
+			Capability: capBaseline.String(),
+		}},
+	}
+
+	for _, tc := range []struct {
+		name string
+		diff Diff
+		want int
+	}{
+		{"allowed addition", Diff{Direction: DiffAdded, Package: "foo/bar", Capability: capAllowed}, PolicyExitOK},
+		{"baseline addition", Diff{Direction: DiffAdded, Package: "foo/bar", Capability: capBaseline}, PolicyExitOK},
+		{"unlisted addition", Diff{Direction: DiffAdded, Package: "foo/bar", Capability: capUnlisted}, PolicyExitUnlisted},
+		{"denied addition", Diff{Direction: DiffAdded, Package: "foo/bar", Capability: capDenied}, PolicyExitDenied},
+		{"removal is never gated", Diff{Direction: DiffRemoved, Package: "foo/bar", Capability: capUnlisted}, PolicyExitOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := policy.Evaluate([]Diff{tc.diff}); got != tc.want {
+				t.Errorf("Evaluate(%+v) = %d, want %d", tc.diff, got, tc.want)
+			}
+		})
+	}
+
+	// A denial anywhere in the batch wins out over an unlisted addition
+	// elsewhere.
+	diffs := []Diff{
+		{Direction: DiffAdded, Package: "foo/bar", Capability: capUnlisted},
+		{Direction: DiffAdded, Package: "foo/bar", Capability: capDenied},
+	}
+	if got := policy.Evaluate(diffs); got != PolicyExitDenied {
+		t.Errorf("Evaluate(%+v) = %d, want %d", diffs, got, PolicyExitDenied)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cpb "github.com/google/capslock/proto"
+)
+
+// Reporter writes a set of Diffs to its underlying writer in some format.
+type Reporter interface {
+	Report(diffs []Diff) error
+}
+
+// NewReporter returns the Reporter for the given -format flag value.
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case "", "text":
+		return &textReporter{w}, nil
+	case "json":
+		return &jsonReporter{w}, nil
+	case "sarif":
+		return &sarifReporter{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q; want text, json, or sarif", format)
+	}
+}
+
+// textReporter reproduces the historical human-oriented diff output.
+type textReporter struct {
+	w io.Writer
+}
+
+func (r *textReporter) Report(diffs []Diff) error {
+	for i, d := range diffs {
+		if i > 0 {
+			fmt.Fprintln(r.w)
+		}
+		prefix := "< "
+		if d.Direction == DiffAdded {
+			prefix = "> "
+		}
+		fmt.Fprintf(r.w, "%sPackage %s has capability %s:\n", prefix, d.Package, d.Capability)
+		r.printCallPath(prefix, d.Path)
+	}
+	return nil
+}
+
+func (r *textReporter) printCallPath(prefix string, fns []*cpb.Function) {
+	tw := tabwriter.NewWriter(
+		r.w, // output
+		10,  // minwidth
+		8,   // tabwidth
+		2,   // padding
+		' ', // padchar
+		0)   // flags
+	for _, f := range fns {
+		tw.Write([]byte(prefix))
+		if f.Site != nil {
+			fmt.Fprint(tw, f.Site.GetFilename(), ":", f.Site.GetLine(), ":", f.Site.GetColumn())
+		}
+		fmt.Fprint(tw, "\t", f.GetName(), "\n")
+	}
+	tw.Flush()
+}
+
+// jsonDiff is the on-the-wire representation of a Diff for -format=json.
+type jsonDiff struct {
+	Direction  string         `json:"direction"`
+	Package    string         `json:"package"`
+	Capability string         `json:"capability"`
+	Path       []jsonFunction `json:"path"`
+}
+
+type jsonFunction struct {
+	Name string `json:"name"`
+	Site string `json:"site,omitempty"`
+}
+
+// jsonReporter emits the diffs as a JSON array for consumption by other
+// tooling.
+type jsonReporter struct {
+	w io.Writer
+}
+
+func (r *jsonReporter) Report(diffs []Diff) error {
+	out := make([]jsonDiff, 0, len(diffs))
+	for _, d := range diffs {
+		jd := jsonDiff{
+			Direction:  d.Direction,
+			Package:    d.Package,
+			Capability: d.Capability.String(),
+		}
+		for _, f := range d.Path {
+			jd.Path = append(jd.Path, jsonFunction{
+				Name: f.GetName(),
+				Site: formatSite(f),
+			})
+		}
+		out = append(out, jd)
+	}
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func formatSite(f *cpb.Function) string {
+	if f.Site == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d:%d", f.Site.GetFilename(), f.Site.GetLine(), f.Site.GetColumn())
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	cpb "github.com/google/capslock/proto"
+)
+
+// The types below are a minimal subset of the SARIF 2.1.0 object model
+// (https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html) needed
+// to report added capabilities as results that code-scanning tools and
+// policy engines can consume.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string `json:"name"`
+	InformationUri string `json:"informationUri,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+	CodeFlows []sarifCodeFlow `json:"codeFlows,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int32 `json:"startLine,omitempty"`
+	StartColumn int32 `json:"startColumn,omitempty"`
+}
+
+type sarifCodeFlow struct {
+	ThreadFlows []sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	Locations []sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location sarifLocation `json:"location"`
+}
+
+// sarifReporter emits one SARIF result per added capability, with the call
+// chain rendered as a code flow so reviewers can walk from the call site in
+// the package that introduced the capability down to the function that has
+// it.
+type sarifReporter struct {
+	w io.Writer
+}
+
+func (r *sarifReporter) Report(diffs []Diff) error {
+	log := sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{
+					Name:           "capslock-git-diff",
+					InformationUri: "https://github.com/google/capslock",
+				},
+			},
+			Results: []sarifResult{},
+		}},
+	}
+	for _, d := range diffs {
+		if d.Direction != DiffAdded {
+			continue
+		}
+		log.Runs[0].Results = append(log.Runs[0].Results, sarifResultFromDiff(d))
+	}
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func sarifResultFromDiff(d Diff) sarifResult {
+	result := sarifResult{
+		RuleID:  d.Capability.String(),
+		Level:   "warning",
+		Message: sarifMessage{Text: fmt.Sprintf("Package %s gained capability %s", d.Package, d.Capability)},
+	}
+	var flowLocations []sarifThreadFlowLocation
+	for _, f := range d.Path {
+		loc := sarifLocationFromFunction(f)
+		flowLocations = append(flowLocations, sarifThreadFlowLocation{Location: loc})
+	}
+	if len(flowLocations) > 0 {
+		result.Locations = []sarifLocation{flowLocations[0].Location}
+		result.CodeFlows = []sarifCodeFlow{{
+			ThreadFlows: []sarifThreadFlow{{Locations: flowLocations}},
+		}}
+	}
+	return result
+}
+
+func sarifLocationFromFunction(f *cpb.Function) sarifLocation {
+	loc := sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: f.GetSite().GetFilename()},
+		},
+	}
+	if f.Site != nil {
+		loc.PhysicalLocation.Region = &sarifRegion{
+			StartLine:   f.Site.GetLine(),
+			StartColumn: f.Site.GetColumn(),
+		}
+	}
+	return loc
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// prepareWorkspace runs any extra setup AnalyzeAtRevision needs before
+// handing tmpdir (a clone of the repository, reset to rev) to capslock:
+// syncing a go.work file at the repository root, and following any
+// replace directives in the analyzed module that point outside the clone.
+func prepareWorkspace(tmpdir, prefix, origRoot, rev string) error {
+	if hasGoWork(tmpdir) {
+		vlog("found go.work at %q; running go work sync", tmpdir)
+		if err := run(nil, tmpdir, nil, "go", "work", "sync"); err != nil {
+			return fmt.Errorf("running go work sync: %w", err)
+		}
+	}
+	moduleDir := filepath.Join(tmpdir, prefix)
+	goModPath := filepath.Join(moduleDir, "go.mod")
+	if _, err := os.Stat(goModPath); err != nil {
+		return nil
+	}
+	targets, err := localReplaceTargets(goModPath)
+	if err != nil {
+		return fmt.Errorf("reading replace directives from %q: %w", goModPath, err)
+	}
+	for _, rel := range targets {
+		_, escapes := escapesRoot(tmpdir, moduleDir, rel)
+		if !escapes {
+			// The target is part of the same repository, and was already
+			// brought along by the clone.
+			continue
+		}
+		origTarget := filepath.Clean(filepath.Join(origRoot, prefix, rel))
+		vlog("replace directive %q escapes the clone; following it to %q", rel, origTarget)
+		newPath, err := cloneSiblingReplace(origTarget, rev)
+		if err != nil {
+			return fmt.Errorf("following replace directive %q: %w", rel, err)
+		}
+		if err := rewriteReplace(goModPath, rel, newPath); err != nil {
+			return fmt.Errorf("rewriting replace directive %q: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// hasGoWork reports whether root contains a go.work file.
+func hasGoWork(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "go.work"))
+	return err == nil
+}
+
+// localReplaceTargets returns the filesystem paths named by the local
+// (directory) replace directives in the go.mod at goModPath.
+func localReplaceTargets(goModPath string) ([]string, error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, r := range f.Replace {
+		if modfile.IsDirectoryPath(r.New.Path) {
+			targets = append(targets, r.New.Path)
+		}
+	}
+	return targets, nil
+}
+
+// escapesRoot reports whether relPath, resolved relative to moduleDir,
+// falls outside of root, and returns the resolved (but not necessarily
+// existing) absolute path.
+func escapesRoot(root, moduleDir, relPath string) (target string, escapes bool) {
+	target = filepath.Clean(filepath.Join(moduleDir, relPath))
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return target, true
+	}
+	return target, rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// cloneSiblingReplace makes a shared (`git clone --shared`) clone of the git repository
+// containing origTarget, reset to rev, and returns the path within that
+// clone corresponding to origTarget.
+func cloneSiblingReplace(origTarget, rev string) (string, error) {
+	var b bytes.Buffer
+	if err := run(&b, origTarget, nil, "git", "rev-parse", "--show-toplevel"); err != nil {
+		return "", fmt.Errorf("%q is not in a git repository: %w", origTarget, err)
+	}
+	siblingRoot := strings.TrimSuffix(b.String(), "\n")
+	b.Reset()
+	if err := run(&b, origTarget, nil, "git", "rev-parse", "--absolute-git-dir"); err != nil {
+		return "", err
+	}
+	gitdir := strings.TrimSuffix(b.String(), "\n")
+	relFromRoot, err := filepath.Rel(siblingRoot, origTarget)
+	if err != nil {
+		return "", err
+	}
+	tmpdir, err := os.MkdirTemp(os.Getenv("CAPSLOCKTOOLSTMPDIR"), "")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary directory: %w", err)
+	}
+	if err := run(nil, "", nil, "git", "clone", "--shared", "--no-checkout", "--", gitdir, tmpdir); err != nil {
+		return "", err
+	}
+	if err := run(nil, tmpdir, nil, "git", "reset", "--hard", rev); err != nil {
+		return "", err
+	}
+	return filepath.Join(tmpdir, relFromRoot), nil
+}
+
+// rewriteReplace points the replace directive in the go.mod at goModPath
+// that targets oldRelPath at newPath instead.
+func rewriteReplace(goModPath, oldRelPath, newPath string) error {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range f.Replace {
+		if r.New.Path != oldRelPath {
+			continue
+		}
+		if err := f.AddReplace(r.Old.Path, r.Old.Version, newPath, ""); err != nil {
+			return err
+		}
+	}
+	f.Cleanup()
+	out, err := f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(goModPath, out, 0o644)
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapesRoot(t *testing.T) {
+	root := filepath.FromSlash("/tmp/repo")
+	moduleDir := filepath.FromSlash("/tmp/repo/cmd/foo")
+	for _, tc := range []struct {
+		name    string
+		relPath string
+		want    bool
+	}{
+		{"sibling within repo", "../bar", false},
+		{"same directory", ".", false},
+		{"parent outside repo", "../../../outside", true},
+		{"absolute-looking escape", "../../../../etc", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, got := escapesRoot(root, moduleDir, tc.relPath); got != tc.want {
+				t.Errorf("escapesRoot(%q, %q, %q) = %v, want %v", root, moduleDir, tc.relPath, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLocalReplaceTargets(t *testing.T) {
+	dir := t.TempDir()
+	goModPath := filepath.Join(dir, "go.mod")
+	contents := `module example.com/m
+
+go 1.21
+
+require example.com/dep v1.2.3
+
+replace example.com/sibling => ../sibling
+
+replace example.com/dep => example.com/dep v1.2.4
+`
+	if err := os.WriteFile(goModPath, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing go.mod: %v", err)
+	}
+	got, err := localReplaceTargets(goModPath)
+	if err != nil {
+		t.Fatalf("localReplaceTargets: %v", err)
+	}
+	want := []string{"../sibling"}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("localReplaceTargets(%q) = %v, want %v", goModPath, got, want)
+	}
+}
@@ -22,6 +22,12 @@
 //
 //	compare some.package/name/foo v1.1 v1.2
 //	compare some.package/name/... v1.1 v1.2
+//
+// By default, compare downloads each version with `go get`, which may hit
+// the network even when the module is already present in GOMODCACHE.  Pass
+// -offline to require that both versions already be extracted in the module
+// cache, or -prefer-cache to use the cache when possible while still
+// allowing a network fallback.
 package main
 
 import (
@@ -33,9 +39,16 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
 )
 
-var verbose = flag.Bool("v", false, "enable verbose logging")
+var (
+	verbose     = flag.Bool("v", false, "enable verbose logging")
+	offline     = flag.Bool("offline", false, "never hit the network; fail if a version isn't already in GOMODCACHE")
+	preferCache = flag.Bool("prefer-cache", false, "reuse an already-extracted GOMODCACHE entry instead of running `go get`, falling back to the network otherwise")
+)
 
 func vlog(format string, a ...any) {
 	if !*verbose {
@@ -56,7 +69,119 @@ func run(w io.Writer, command string, args ...string) error {
 	return nil
 }
 
-func MakeWorkspace(pkgname string) error {
+// runEnv executes the specified command with extra environment variables
+// appended to the current environment, and writes its stdout to w.
+func runEnv(w io.Writer, env []string, command string, args ...string) error {
+	vlog("running %q with args %q and env %q", command, args, env)
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %q with args %q: %w", command, args, err)
+	}
+	return nil
+}
+
+// ComparePackagesOptions controls how ComparePackages obtains the source for
+// each version it compares.
+type ComparePackagesOptions struct {
+	// Offline, if true, requires that every version being compared is
+	// already extracted in GOMODCACHE.  ComparePackages returns an error
+	// instead of contacting the network.
+	Offline bool
+	// PreferCache, if true, reuses an already-extracted GOMODCACHE entry
+	// instead of running `go get`, but still allows `go get` to reach the
+	// network for versions that aren't cached.
+	PreferCache bool
+}
+
+// gomodcache returns the value of `go env GOMODCACHE`.
+func gomodcache() (string, error) {
+	var b strings.Builder
+	if err := run(&b, "go", "env", "GOMODCACHE"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// candidateModulePaths returns the possible module paths that could contain
+// pkg, a package path or pattern as accepted by `go get` (e.g.
+// "some.package/name/foo" or "some.package/name/..."), most specific first:
+// pkg itself, then each enclosing directory up to the root.  GOMODCACHE is
+// keyed by module path, which for a sub-package of a module is some prefix
+// of the package's import path, not the package path itself.
+func candidateModulePaths(pkg string) []string {
+	pkg = strings.TrimSuffix(pkg, "/...")
+	if pkg == "" || pkg == "..." {
+		return nil
+	}
+	var candidates []string
+	for {
+		candidates = append(candidates, pkg)
+		idx := strings.LastIndex(pkg, "/")
+		if idx < 0 {
+			return candidates
+		}
+		pkg = pkg[:idx]
+	}
+}
+
+// cachedModuleDir looks for an already-extracted GOMODCACHE entry for pkg at
+// the given version, trying pkg itself and each of its enclosing directories
+// as the module path.  It returns the cache directory, the module path that
+// matched, and whether a match was found.
+func cachedModuleDir(gomodcache, pkg, version string) (dir, modPath string, ok bool) {
+	for _, candidate := range candidateModulePaths(pkg) {
+		escaped, err := module.EscapePath(candidate)
+		if err != nil {
+			continue
+		}
+		d := path.Join(gomodcache, escaped+"@"+version)
+		if info, err := os.Stat(d); err == nil && info.IsDir() {
+			return d, candidate, true
+		}
+	}
+	return "", "", false
+}
+
+// cacheEnv returns the environment variables that make `go` commands prefer
+// the local module cache over the network.  If offline is true, the network
+// is disallowed entirely instead of merely de-prioritized.
+func cacheEnv(cache string, offline bool) []string {
+	proxy := "GOPROXY=off"
+	if !offline {
+		proxy = "GOPROXY=file://" + path.Join(cache, "cache", "download") + ",direct"
+	}
+	return []string{"GOFLAGS=-mod=mod", proxy}
+}
+
+// populateFromCache builds a go.mod requiring pkg@version, replaced by the
+// already-extracted module cache directory at dir, then runs `go mod tidy`
+// to pull in a go.sum covering pkg and its transitive dependencies from
+// GOMODCACHE.  Without this, the generated go.mod has no checksum database
+// and later `go build`/capslock invocations fail under the default
+// -mod=readonly once they need a package outside of pkg itself.
+func populateFromCache(pkg, version, dir, cache string, offline bool) error {
+	if err := run(nil, "go", "mod", "init", "capslockworkspace"); err != nil {
+		return err
+	}
+	if err := run(nil, "go", "mod", "edit", "-require="+pkg+"@"+version); err != nil {
+		return err
+	}
+	if err := run(nil, "go", "mod", "edit", "-replace="+pkg+"@"+version+"="+dir); err != nil {
+		return err
+	}
+	if err := runEnv(nil, cacheEnv(cache, offline), "go", "mod", "tidy"); err != nil {
+		if offline {
+			return fmt.Errorf("%s@%s is not fully cached in GOMODCACHE (%s) and -offline was given: %w", pkg, version, cache, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func MakeWorkspace(pkgname string, opts ComparePackagesOptions) error {
 	tmpdir, err := os.MkdirTemp(os.Getenv("CAPSLOCKTOOLSTMPDIR"), "")
 	if err != nil {
 		return fmt.Errorf("creating temporary directory: %w", err)
@@ -64,13 +189,28 @@ func MakeWorkspace(pkgname string) error {
 	if err = os.Chdir(tmpdir); err != nil {
 		return fmt.Errorf("switching to temporary directory: %w", err)
 	}
-	if err = run(nil, "go", "mod", "init", "capslockworkspace"); err != nil {
-		return err
+	pkg, version, hasVersion := strings.Cut(pkgname, "@")
+	if !hasVersion || (!opts.Offline && !opts.PreferCache) {
+		if err = run(nil, "go", "mod", "init", "capslockworkspace"); err != nil {
+			return err
+		}
+		return run(nil, "go", "get", pkgname)
 	}
-	if err := run(nil, "go", "get", pkgname); err != nil {
+	cache, err := gomodcache()
+	if err != nil {
+		return fmt.Errorf("looking up GOMODCACHE: %w", err)
+	}
+	if dir, modPath, ok := cachedModuleDir(cache, pkg, version); ok {
+		vlog("reusing cached module %q at %q", modPath, dir)
+		return populateFromCache(modPath, version, dir, cache, opts.Offline)
+	}
+	if opts.Offline {
+		return fmt.Errorf("%s is not in GOMODCACHE (%s) and -offline was given", pkgname, cache)
+	}
+	if err = run(nil, "go", "mod", "init", "capslockworkspace"); err != nil {
 		return err
 	}
-	return nil
+	return runEnv(nil, cacheEnv(cache, false), "go", "get", pkgname)
 }
 
 func CreateCapabilitiesFile(pkgname string) (filename string, err error) {
@@ -89,10 +229,10 @@ func CreateCapabilitiesFile(pkgname string) (filename string, err error) {
 	return filename, f.Close()
 }
 
-func ComparePackages(pkgname, version1, version2 string) (ranComparison bool, err error) {
+func ComparePackages(pkgname, version1, version2 string, opts ComparePackagesOptions) (ranComparison bool, err error) {
 	create := func(pkg string) error {
 		vlog("Creating workspace for %q", pkg)
-		if err := MakeWorkspace(pkg); err != nil {
+		if err := MakeWorkspace(pkg, opts); err != nil {
 			return fmt.Errorf("creating temporary workspace for analyzing %q: %w", pkg, err)
 		}
 		return nil
@@ -117,7 +257,11 @@ func main() {
 	if len(a) != 3 {
 		panic(fmt.Sprintf("wrong number of arguments: %q", a))
 	}
-	ranComparison, err := ComparePackages(a[0], a[1], a[2])
+	opts := ComparePackagesOptions{
+		Offline:     *offline,
+		PreferCache: *preferCache,
+	}
+	ranComparison, err := ComparePackages(a[0], a[1], a[2], opts)
 	if err != nil {
 		var e *exec.ExitError
 		if ranComparison && errors.As(err, &e) && e.ProcessState != nil {